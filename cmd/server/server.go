@@ -2,14 +2,21 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
@@ -119,15 +126,46 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 			return xerrors.Errorf("failed to setup process: %w", err)
 		}
 	}
+	unixSocket := viper.GetString(FlagUnixSocket)
+	tlsMinVersion, ok := tlsVersions[viper.GetString(FlagTLSMinVersion)]
+	if !ok {
+		return xerrors.Errorf("invalid TLS min version %q: must be one of 1.2, 1.3", viper.GetString(FlagTLSMinVersion))
+	}
+	unixSocketMode, err := parseUnixSocketMode(viper.Get(FlagUnixSocketMode))
+	if err != nil {
+		return xerrors.Errorf("invalid unix socket mode: %w", err)
+	}
+	routeTimeouts, err := parseRouteTimeouts(viper.GetStringSlice(FlagRouteTimeout))
+	if err != nil {
+		return xerrors.Errorf("invalid route timeout: %w", err)
+	}
 	port := viper.GetInt(FlagPort)
 	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
-		AgentType:      agentType,
-		Process:        process,
-		Port:           port,
-		ChatBasePath:   viper.GetString(FlagChatBasePath),
-		AllowedHosts:   viper.GetStringSlice(FlagAllowedHosts),
-		AllowedOrigins: viper.GetStringSlice(FlagAllowedOrigins),
-		InitialPrompt:  initialPrompt,
+		AgentType:       agentType,
+		Process:         process,
+		Port:            port,
+		ChatBasePath:    viper.GetString(FlagChatBasePath),
+		AllowedHosts:    viper.GetStringSlice(FlagAllowedHosts),
+		AllowedOrigins:  viper.GetStringSlice(FlagAllowedOrigins),
+		InitialPrompt:   initialPrompt,
+		TLSCertFile:     viper.GetString(FlagTLSCert),
+		TLSKeyFile:      viper.GetString(FlagTLSKey),
+		TLSMinVersion:   tlsMinVersion,
+		TLSClientCAFile: viper.GetString(FlagTLSClientCA),
+		UnixSocket:      unixSocket,
+		UnixSocketMode:  unixSocketMode,
+		Timeouts: httpapi.Timeouts{
+			ReadHeader: viper.GetDuration(FlagReadHeaderTimeout),
+			Read:       viper.GetDuration(FlagReadTimeout),
+			Write:      viper.GetDuration(FlagWriteTimeout),
+			Idle:       viper.GetDuration(FlagIdleTimeout),
+		},
+		RateLimit:     viper.GetInt(FlagRateLimit),
+		RouteTimeouts: routeTimeouts,
+		AccessLog: httpapi.AccessLogConfig{
+			Disabled:      !viper.GetBool(FlagAccessLog),
+			ExcludedPaths: viper.GetStringSlice(FlagAccessLogExclude),
+		},
 	})
 	if err != nil {
 		return xerrors.Errorf("failed to create server: %w", err)
@@ -136,30 +174,150 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 		fmt.Println(srv.GetOpenAPI())
 		return nil
 	}
-	logger.Info("Starting server on port", "port", port)
-	processExitCh := make(chan error, 1)
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+	if err := serveUntilExit(ctx, logger, srv, process, signalCh, viper.GetDuration(FlagShutdownTimeout)); err != nil {
+		return xerrors.Errorf("agent exited with error: %w", err)
+	}
+	return nil
+}
+
+// agentServer is the part of httpapi.Server that serveUntilExit drives.
+type agentServer interface {
+	Start() error
+	Stop(ctx context.Context) error
+}
+
+// agentProcess is the part of termexec.Process that serveUntilExit drives.
+type agentProcess interface {
+	Wait() error
+	ReadScreen() string
+	Close(logger *slog.Logger, timeout time.Duration) error
+}
+
+// serveUntilExit serves HTTP until the agent exits or a signal arrives on
+// signalCh, and only returns once the server has stopped. On a signal the
+// server stops accepting connections and drains in-flight requests before the
+// agent is closed, so that requests talking to the agent can finish.
+// gracePeriod bounds the whole shutdown. The error is the agent's if it exited
+// on its own.
+func serveUntilExit(ctx context.Context, logger *slog.Logger, srv agentServer, process agentProcess, signalCh <-chan os.Signal, gracePeriod time.Duration) error {
+	waitCh := make(chan error, 1)
 	go func() {
-		defer close(processExitCh)
-		if err := process.Wait(); err != nil {
+		waitCh <- process.Wait()
+	}()
+
+	exitCh := make(chan error, 1)
+	go func() {
+		defer close(exitCh)
+		var exitErr error
+		select {
+		case err := <-waitCh:
 			if errors.Is(err, termexec.ErrNonZeroExitCode) {
-				processExitCh <- xerrors.Errorf("========\n%s\n========\n: %w", strings.TrimSpace(process.ReadScreen()), err)
-			} else {
-				processExitCh <- xerrors.Errorf("failed to wait for process: %w", err)
+				exitErr = xerrors.Errorf("========\n%s\n========\n: %w", strings.TrimSpace(process.ReadScreen()), err)
+			} else if err != nil {
+				exitErr = xerrors.Errorf("failed to wait for process: %w", err)
+			}
+			stopCtx, cancel := context.WithTimeout(ctx, gracePeriod)
+			defer cancel()
+			if err := srv.Stop(stopCtx); err != nil {
+				logger.Error("Failed to stop server", "error", err)
+			}
+		case sig := <-signalCh:
+			logger.Info("Shutting down", "signal", sig.String())
+			stopCtx, cancel := context.WithTimeout(ctx, gracePeriod)
+			defer cancel()
+			if err := srv.Stop(stopCtx); err != nil {
+				logger.Error("Failed to stop server", "error", err)
+			}
+			// The agent gets whatever is left of the grace period.
+			deadline, _ := stopCtx.Deadline()
+			if err := process.Close(logger, max(time.Until(deadline), 0)); err != nil {
+				logger.Error("Error closing process", "error", err)
 			}
 		}
-		if err := srv.Stop(ctx); err != nil {
-			logger.Error("Failed to stop server", "error", err)
-		}
+		exitCh <- exitErr
 	}()
+
 	if err := srv.Start(); err != nil && err != context.Canceled && err != http.ErrServerClosed {
 		return xerrors.Errorf("failed to start server: %w", err)
 	}
-	select {
-	case err := <-processExitCh:
-		return xerrors.Errorf("agent exited with error: %w", err)
+	return <-exitCh
+}
+
+// tlsVersions maps the accepted values of --tls-min-version to their tls.VersionTLS* constants.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseRouteTimeouts parses PATTERN=DURATION entries, e.g. "/message=5m".
+func parseRouteTimeouts(entries []string) ([]httpapi.RouteTimeout, error) {
+	routeTimeouts := make([]httpapi.RouteTimeout, 0, len(entries))
+	for _, entry := range entries {
+		pattern, value, ok := strings.Cut(entry, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("%q must be of the form PATTERN=DURATION", entry)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("%q: timeout must be positive", entry)
+		}
+		routeTimeouts = append(routeTimeouts, httpapi.RouteTimeout{Pattern: pattern, Timeout: timeout})
+	}
+	return routeTimeouts, nil
+}
+
+// parseUnixSocketMode accepts the socket mode as an octal string, which is
+// what flags and env vars provide, or as a number decoded from a config file.
+// A number is taken at face value, so config files must spell it as an octal
+// literal (0660 or 0o660 in YAML, 0o660 in TOML): a plain 420 means 0644. JSON
+// has no octal literals and decodes numbers to float64, so there the mode is
+// best written as a string.
+func parseUnixSocketMode(value any) (fs.FileMode, error) {
+	var mode uint64
+	switch v := value.(type) {
+	case string:
+		digits := strings.TrimPrefix(strings.TrimPrefix(v, "0o"), "0O")
+		parsed, err := strconv.ParseUint(digits, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an octal number", v)
+		}
+		mode = parsed
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("%d must not be negative", v)
+		}
+		mode = uint64(v)
+	case int64:
+		if v < 0 {
+			return 0, fmt.Errorf("%d must not be negative", v)
+		}
+		mode = uint64(v)
+	case uint64:
+		mode = v
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("%v is not a whole number", v)
+		}
+		if v < 0 {
+			return 0, fmt.Errorf("%v must not be negative", v)
+		}
+		mode = uint64(v)
 	default:
+		return 0, fmt.Errorf("unsupported value %v of type %T, use an octal string", value, value)
 	}
-	return nil
+	if mode == 0 {
+		return 0, fmt.Errorf("mode 0 would make the socket unusable")
+	}
+	if mode > uint64(fs.ModePerm) {
+		return 0, fmt.Errorf("%#o is not a permission mode, it must be at most 0777", mode)
+	}
+	return fs.FileMode(mode), nil
 }
 
 var agentNames = (func() []string {
@@ -190,8 +348,41 @@ const (
 	FlagAllowedOrigins = "allowed-origins"
 	FlagExit           = "exit"
 	FlagInitialPrompt  = "initial-prompt"
+	FlagConfig         = "config"
+	FlagTLSCert        = "tls-cert"
+	FlagTLSKey         = "tls-key"
+	FlagTLSMinVersion  = "tls-min-version"
+	FlagTLSClientCA    = "tls-client-ca"
+	FlagUnixSocket     = "unix-socket"
+	FlagUnixSocketMode = "unix-socket-mode"
+
+	FlagReadHeaderTimeout = "read-header-timeout"
+	FlagReadTimeout       = "read-timeout"
+	FlagWriteTimeout      = "write-timeout"
+	FlagIdleTimeout       = "idle-timeout"
+	FlagShutdownTimeout   = "shutdown-timeout"
+
+	FlagAccessLog        = "access-log"
+	FlagAccessLogExclude = "access-log-exclude"
+	FlagRateLimit        = "rate-limit"
+	FlagRouteTimeout     = "route-timeout"
 )
 
+// readConfigFile loads the file passed via --config, if any. Viper picks the
+// format (YAML, TOML, JSON) from the file extension. Values from the file take
+// precedence over defaults but not over flags or AGENTAPI_* env vars.
+func readConfigFile() error {
+	path := viper.GetString(FlagConfig)
+	if path == "" {
+		return nil
+	}
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		return xerrors.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return nil
+}
+
 func CreateServerCmd() *cobra.Command {
 	serverCmd := &cobra.Command{
 		Use:   "server [agent]",
@@ -199,6 +390,12 @@ func CreateServerCmd() *cobra.Command {
 		Long:  fmt.Sprintf("Run the server with the specified agent (one of: %s)", strings.Join(agentNames, ", ")),
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			// Read the config file before --exit so the test suite can
+			// validate how its values are merged.
+			if err := readConfigFile(); err != nil {
+				fmt.Fprintf(os.Stderr, "%+v\n", err)
+				os.Exit(1)
+			}
 			// The --exit flag is used for testing validation of flags in the test suite
 			if viper.GetBool(FlagExit) {
 				return
@@ -228,6 +425,23 @@ func CreateServerCmd() *cobra.Command {
 		// localhost:3284 is the default origin when you open the chat interface in your browser. localhost:3000 and 3001 are used during development.
 		{FlagAllowedOrigins, "o", []string{"http://localhost:3284", "http://localhost:3000", "http://localhost:3001"}, "HTTP allowed origins. Use '*' for all, comma-separated list via flag, space-separated list via AGENTAPI_ALLOWED_ORIGINS env var", "stringSlice"},
 		{FlagInitialPrompt, "I", "", "Initial prompt for the agent. Recommended only if the agent doesn't support initial prompt in interaction mode. Will be read from stdin if piped (e.g., echo 'prompt' | agentapi server -- my-agent)", "string"},
+		{FlagTLSCert, "", "", "Path to a PEM-encoded TLS certificate. Serves HTTPS when set together with --tls-key. During TLS handshakes, at most once every 10 seconds, the certificate and key are checked for changes and reloaded", "string"},
+		{FlagTLSKey, "", "", "Path to the PEM-encoded private key for --tls-cert", "string"},
+		{FlagTLSMinVersion, "", "1.2", "Minimum TLS version to accept (one of: 1.2, 1.3)", "string"},
+		{FlagTLSClientCA, "", "", "Path to PEM-encoded CA certificates. When set, clients must present a certificate signed by one of them", "string"},
+		{FlagUnixSocket, "", "", "Listen on this unix domain socket path instead of the TCP port", "string"},
+		{FlagUnixSocketMode, "", "0600", "File permissions of the unix domain socket, in octal. Numbers in config files must be written as octal literals such as 0o660", "string"},
+		{FlagReadHeaderTimeout, "", 10 * time.Second, "Maximum time to read request headers. 0 disables the timeout", "duration"},
+		{FlagReadTimeout, "", time.Duration(0), "Maximum time to read an entire request, including the body. 0 disables the timeout", "duration"},
+		{FlagWriteTimeout, "", time.Duration(0), "Maximum time to write a response. Not applied to SSE streams. 0 disables the timeout", "duration"},
+		{FlagIdleTimeout, "", 2 * time.Minute, "Maximum time to keep an idle keep-alive connection open. 0 disables the timeout", "duration"},
+		{FlagShutdownTimeout, "", 10 * time.Second, "Grace period for shutting down, covering both in-flight requests and closing the agent", "duration"},
+		// Status polling and the long-lived event streams would drown out everything else.
+		{FlagAccessLog, "", true, "Log every HTTP request", "bool"},
+		{FlagAccessLogExclude, "", []string{"/status", "/events", "/internal/screen"}, "Paths left out of the access log. A trailing '*' matches by prefix. Comma-separated list via flag, space-separated list via AGENTAPI_ACCESS_LOG_EXCLUDE env var", "stringSlice"},
+		{FlagRateLimit, "", 0, "Maximum requests per minute each client IP can make to each route. 0 disables rate limiting", "int"},
+		{FlagRouteTimeout, "", []string{}, "Request deadlines by path as PATTERN=DURATION, e.g. /status=5s. A trailing '*' in the pattern matches by prefix and the first match wins. /events and /internal/screen never time out. Comma-separated list via flag, space-separated list via AGENTAPI_ROUTE_TIMEOUT env var", "stringSlice"},
+		{FlagConfig, "", "", "Path to a YAML, TOML, or JSON config file whose keys are the flag names above. Flags and AGENTAPI_* env vars take precedence over values in the file", "string"},
 	}
 
 	for _, spec := range flagSpecs {
@@ -240,6 +454,8 @@ func CreateServerCmd() *cobra.Command {
 			serverCmd.Flags().BoolP(spec.name, spec.shorthand, spec.defaultValue.(bool), spec.usage)
 		case "uint16":
 			serverCmd.Flags().Uint16P(spec.name, spec.shorthand, spec.defaultValue.(uint16), spec.usage)
+		case "duration":
+			serverCmd.Flags().DurationP(spec.name, spec.shorthand, spec.defaultValue.(time.Duration), spec.usage)
 		case "stringSlice":
 			serverCmd.Flags().StringSliceP(spec.name, spec.shorthand, spec.defaultValue.([]string), spec.usage)
 		default:
@@ -1,11 +1,22 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/coder/agentapi/lib/httpapi"
+	"github.com/coder/agentapi/lib/logctx"
+	"github.com/coder/agentapi/lib/termexec"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -232,6 +243,17 @@ func TestServerCmd_AllArgs_Defaults(t *testing.T) {
 		{"term-height default", FlagTermHeight, uint16(1000), func() any { return viper.GetUint16(FlagTermHeight) }},
 		{"allowed-hosts default", FlagAllowedHosts, []string{"localhost", "127.0.0.1", "[::1]"}, func() any { return viper.GetStringSlice(FlagAllowedHosts) }},
 		{"allowed-origins default", FlagAllowedOrigins, []string{"http://localhost:3284", "http://localhost:3000", "http://localhost:3001"}, func() any { return viper.GetStringSlice(FlagAllowedOrigins) }},
+		{"read-header-timeout default", FlagReadHeaderTimeout, 10 * time.Second, func() any { return viper.GetDuration(FlagReadHeaderTimeout) }},
+		{"read-timeout default", FlagReadTimeout, time.Duration(0), func() any { return viper.GetDuration(FlagReadTimeout) }},
+		{"write-timeout default", FlagWriteTimeout, time.Duration(0), func() any { return viper.GetDuration(FlagWriteTimeout) }},
+		{"idle-timeout default", FlagIdleTimeout, 2 * time.Minute, func() any { return viper.GetDuration(FlagIdleTimeout) }},
+		{"shutdown-timeout default", FlagShutdownTimeout, 10 * time.Second, func() any { return viper.GetDuration(FlagShutdownTimeout) }},
+		{"tls-min-version default", FlagTLSMinVersion, "1.2", func() any { return viper.GetString(FlagTLSMinVersion) }},
+		{"tls-client-ca default", FlagTLSClientCA, "", func() any { return viper.GetString(FlagTLSClientCA) }},
+		{"access-log default", FlagAccessLog, true, func() any { return viper.GetBool(FlagAccessLog) }},
+		{"rate-limit default", FlagRateLimit, 0, func() any { return viper.GetInt(FlagRateLimit) }},
+		{"route-timeout default", FlagRouteTimeout, []string{}, func() any { return viper.GetStringSlice(FlagRouteTimeout) }},
+		{"access-log-exclude default", FlagAccessLogExclude, []string{"/status", "/events", "/internal/screen"}, func() any { return viper.GetStringSlice(FlagAccessLogExclude) }},
 	}
 
 	for _, tt := range tests {
@@ -266,6 +288,12 @@ func TestServerCmd_AllEnvVars(t *testing.T) {
 		{"AGENTAPI_TERM_HEIGHT", "AGENTAPI_TERM_HEIGHT", "500", uint16(500), func() any { return viper.GetUint16(FlagTermHeight) }},
 		{"AGENTAPI_ALLOWED_HOSTS", "AGENTAPI_ALLOWED_HOSTS", "localhost example.com", []string{"localhost", "example.com"}, func() any { return viper.GetStringSlice(FlagAllowedHosts) }},
 		{"AGENTAPI_ALLOWED_ORIGINS", "AGENTAPI_ALLOWED_ORIGINS", "https://example.com http://localhost:3000", []string{"https://example.com", "http://localhost:3000"}, func() any { return viper.GetStringSlice(FlagAllowedOrigins) }},
+		{"AGENTAPI_READ_HEADER_TIMEOUT", "AGENTAPI_READ_HEADER_TIMEOUT", "5s", 5 * time.Second, func() any { return viper.GetDuration(FlagReadHeaderTimeout) }},
+		{"AGENTAPI_WRITE_TIMEOUT", "AGENTAPI_WRITE_TIMEOUT", "1m", time.Minute, func() any { return viper.GetDuration(FlagWriteTimeout) }},
+		{"AGENTAPI_TLS_MIN_VERSION", "AGENTAPI_TLS_MIN_VERSION", "1.3", "1.3", func() any { return viper.GetString(FlagTLSMinVersion) }},
+		{"AGENTAPI_RATE_LIMIT", "AGENTAPI_RATE_LIMIT", "120", 120, func() any { return viper.GetInt(FlagRateLimit) }},
+		{"AGENTAPI_ACCESS_LOG", "AGENTAPI_ACCESS_LOG", "false", false, func() any { return viper.GetBool(FlagAccessLog) }},
+		{"AGENTAPI_ACCESS_LOG_EXCLUDE", "AGENTAPI_ACCESS_LOG_EXCLUDE", "/status /chat/*", []string{"/status", "/chat/*"}, func() any { return viper.GetStringSlice(FlagAccessLogExclude) }},
 	}
 
 	for _, tt := range tests {
@@ -389,6 +417,70 @@ func TestMixed_ConfigurationScenarios(t *testing.T) {
 	})
 }
 
+func TestServerCmd_ConfigFile(t *testing.T) {
+	writeConfig := func(t *testing.T, name, content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	t.Run("yaml values are used", func(t *testing.T) {
+		isolateViper(t)
+		path := writeConfig(t, "agentapi.yaml", "port: 7777\ntype: goose\nallowed-hosts:\n  - example.com\n  - example.org\n")
+
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--config", path, "--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		assert.Equal(t, 7777, viper.GetInt(FlagPort))
+		assert.Equal(t, "goose", viper.GetString(FlagType))
+		assert.Equal(t, []string{"example.com", "example.org"}, viper.GetStringSlice(FlagAllowedHosts))
+		assert.Equal(t, "/chat", viper.GetString(FlagChatBasePath)) // default
+	})
+
+	t.Run("json values are used", func(t *testing.T) {
+		isolateViper(t)
+		path := writeConfig(t, "agentapi.json", `{"term-width": 120, "chat-base-path": "/json"}`)
+
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--config", path, "--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		assert.Equal(t, uint16(120), viper.GetUint16(FlagTermWidth))
+		assert.Equal(t, "/json", viper.GetString(FlagChatBasePath))
+	})
+
+	t.Run("env and flags override the file", func(t *testing.T) {
+		isolateViper(t)
+		path := writeConfig(t, "agentapi.toml", "port = 7777\ntype = \"goose\"\n")
+		t.Setenv("AGENTAPI_TYPE", "aider")
+
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--config", path, "--port", "9999", "--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		assert.Equal(t, 9999, viper.GetInt(FlagPort))       // from CLI
+		assert.Equal(t, "aider", viper.GetString(FlagType)) // from env
+	})
+
+	t.Run("config path from env", func(t *testing.T) {
+		isolateViper(t)
+		path := writeConfig(t, "agentapi.yaml", "port: 7777\n")
+		t.Setenv("AGENTAPI_CONFIG", path)
+
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		assert.Equal(t, 7777, viper.GetInt(FlagPort))
+	})
+}
+
 func TestServerCmd_AllowedHosts(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -571,3 +663,197 @@ func TestServerCmd_AllowedOrigins(t *testing.T) {
 		})
 	}
 }
+
+func TestParseUnixSocketMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       any
+		expected    fs.FileMode
+		expectedErr string
+	}{
+		{name: "octal string", value: "0660", expected: 0o660},
+		{name: "octal string without leading zero", value: "600", expected: 0o600},
+		{name: "0o prefix", value: "0o640", expected: 0o640},
+		{name: "decoded number", value: 0o660, expected: 0o660},
+		{name: "decoded int64", value: int64(0o660), expected: 0o660},
+		{name: "decoded json number", value: float64(384), expected: 0o600},
+		{name: "plain number is not octal", value: 420, expected: 0o644},
+		{name: "zero", value: "0", expectedErr: "unusable"},
+		{name: "not octal", value: "0680", expectedErr: "not an octal number"},
+		{name: "too large", value: "1777", expectedErr: "at most 0777"},
+		{name: "negative", value: -1, expectedErr: "must not be negative"},
+		{name: "fractional number", value: 1.5, expectedErr: "not a whole number"},
+		{name: "negative json number", value: float64(-1), expectedErr: "must not be negative"},
+		{name: "too large json number", value: float64(0o1000), expectedErr: "at most 0777"},
+		{name: "wrong type", value: true, expectedErr: "unsupported value true of type bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := parseUnixSocketMode(tt.value)
+			if tt.expectedErr != "" {
+				require.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, mode)
+		})
+	}
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	routeTimeouts, err := parseRouteTimeouts([]string{"/status=5s", "/chat/*=1m"})
+	require.NoError(t, err)
+	assert.Equal(t, []httpapi.RouteTimeout{
+		{Pattern: "/status", Timeout: 5 * time.Second},
+		{Pattern: "/chat/*", Timeout: time.Minute},
+	}, routeTimeouts)
+
+	for _, entry := range []string{"/status", "=5s", "/status=soon", "/status=0s"} {
+		t.Run(entry, func(t *testing.T) {
+			_, err := parseRouteTimeouts([]string{entry})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestServerCmd_UnixSocketMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		config string
+		env    map[string]string
+		args   []string
+	}{
+		{name: "yaml", file: "agentapi.yaml", config: "unix-socket-mode: 0660\n"},
+		{name: "yaml with 0o prefix", file: "agentapi.yaml", config: "unix-socket-mode: 0o660\n"},
+		{name: "toml", file: "agentapi.toml", config: "unix-socket-mode = 0o660\n"},
+		{name: "json string", file: "agentapi.json", config: `{"unix-socket-mode": "0660"}`},
+		{name: "json number", file: "agentapi.json", config: `{"unix-socket-mode": 432}`},
+		{name: "yaml decimal number", file: "agentapi.yaml", config: "unix-socket-mode: 432\n"},
+		{name: "env", env: map[string]string{"AGENTAPI_UNIX_SOCKET_MODE": "0660"}},
+		{name: "flag", args: []string{"--unix-socket-mode", "0660"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateViper(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), tt.file)
+				require.NoError(t, os.WriteFile(path, []byte(tt.config), 0o644))
+				args = append(args, "--config", path)
+			}
+
+			serverCmd := CreateServerCmd()
+			setupCommandOutput(t, serverCmd)
+			serverCmd.SetArgs(append(args, "--exit", "dummy-command"))
+			require.NoError(t, serverCmd.Execute())
+
+			mode, err := parseUnixSocketMode(viper.Get(FlagUnixSocketMode))
+			require.NoError(t, err)
+			assert.Equal(t, fs.FileMode(0o660), mode)
+		})
+	}
+}
+
+// fakeServer mimics httpapi.Server: Start returns as soon as Stop begins, and
+// Stop blocks until the test lets the drain finish.
+type fakeServer struct {
+	stopping    chan struct{}
+	finishDrain chan struct{}
+	stopped     atomic.Bool
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{stopping: make(chan struct{}), finishDrain: make(chan struct{})}
+}
+
+func (s *fakeServer) Start() error {
+	<-s.stopping
+	return http.ErrServerClosed
+}
+
+func (s *fakeServer) Stop(context.Context) error {
+	close(s.stopping)
+	<-s.finishDrain
+	s.stopped.Store(true)
+	return nil
+}
+
+type fakeProcess struct {
+	waitErr      error
+	exited       chan struct{}
+	closeTimeout chan time.Duration
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.exited
+	return p.waitErr
+}
+
+func (p *fakeProcess) ReadScreen() string {
+	return "agent screen"
+}
+
+func (p *fakeProcess) Close(_ *slog.Logger, timeout time.Duration) error {
+	p.closeTimeout <- timeout
+	close(p.exited)
+	return nil
+}
+
+func TestServeUntilExit(t *testing.T) {
+	logger := slog.New(logctx.DiscardHandler)
+
+	t.Run("agent fails", func(t *testing.T) {
+		srv := newFakeServer()
+		process := &fakeProcess{waitErr: termexec.ErrNonZeroExitCode, exited: make(chan struct{})}
+		close(process.exited)
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- serveUntilExit(context.Background(), logger, srv, process, nil, time.Minute)
+		}()
+		<-srv.stopping
+		select {
+		case err := <-errCh:
+			t.Fatalf("returned before the server finished draining: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(srv.finishDrain)
+		err := <-errCh
+		require.True(t, srv.stopped.Load())
+		require.ErrorIs(t, err, termexec.ErrNonZeroExitCode)
+		require.ErrorContains(t, err, "agent screen")
+	})
+
+	t.Run("signal", func(t *testing.T) {
+		srv := newFakeServer()
+		process := &fakeProcess{exited: make(chan struct{}), closeTimeout: make(chan time.Duration, 1)}
+		signalCh := make(chan os.Signal, 1)
+		signalCh <- syscall.SIGTERM
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- serveUntilExit(context.Background(), logger, srv, process, signalCh, time.Minute)
+		}()
+		<-srv.stopping
+		// The agent is only closed once the server has drained.
+		select {
+		case <-process.closeTimeout:
+			t.Fatal("agent closed before the server finished draining")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(srv.finishDrain)
+		timeout := <-process.closeTimeout
+		require.NoError(t, <-errCh)
+		require.True(t, srv.stopped.Load())
+		assert.Positive(t, timeout)
+		assert.LessOrEqual(t, timeout, time.Minute)
+	})
+}
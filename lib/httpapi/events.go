@@ -18,6 +18,7 @@ const (
 	EventTypeMessageUpdate EventType = "message_update"
 	EventTypeStatusChange  EventType = "status_change"
 	EventTypeScreenUpdate  EventType = "screen_update"
+	EventTypeShutdown      EventType = "server_shutdown"
 )
 
 type AgentStatus string
@@ -52,7 +53,16 @@ type ScreenUpdateBody struct {
 	Screen string `json:"screen"`
 }
 
+type ShutdownBody struct {
+	Message string `json:"message" doc:"Reason the stream is ending. The server closes the stream right after this event."`
+}
+
 type Event struct {
+	// Id is sent as the SSE `id:` field. It is opaque to clients: ids
+	// increase over the lifetime of the emitter, but they are shared by all
+	// event types, so a stream that filters events sees gaps. Zero means the
+	// event has not been assigned an identifier.
+	Id      int
 	Type    EventType
 	Payload any
 }
@@ -64,8 +74,12 @@ type EventEmitter struct {
 	agentType           mf.AgentType
 	chans               map[int]chan Event
 	chanIdx             int
+	eventIdx            int
 	subscriptionBufSize int
 	screen              string
+	// closed is set by Shutdown. Later subscribers get the shutdown event and
+	// a closed channel instead of a live subscription.
+	closed bool
 }
 
 func convertStatus(status st.ConversationStatus) AgentStatus {
@@ -93,12 +107,14 @@ func NewEventEmitter(subscriptionBufSize int) *EventEmitter {
 		status:              AgentStatusRunning,
 		chans:               make(map[int]chan Event),
 		chanIdx:             0,
+		eventIdx:            0,
 		subscriptionBufSize: subscriptionBufSize,
 	}
 }
 
 // Assumes the caller holds the lock.
 func (e *EventEmitter) notifyChannels(eventType EventType, payload any) {
+	e.eventIdx++
 	chanIds := make([]int, 0, len(e.chans))
 	for chanId := range e.chans {
 		chanIds = append(chanIds, chanId)
@@ -106,6 +122,7 @@ func (e *EventEmitter) notifyChannels(eventType EventType, payload any) {
 	for _, chanId := range chanIds {
 		ch := e.chans[chanId]
 		event := Event{
+			Id:      e.eventIdx,
 			Type:    eventType,
 			Payload: payload,
 		}
@@ -175,20 +192,25 @@ func (e *EventEmitter) UpdateScreenAndEmitChanges(newScreen string) {
 	e.screen = newScreen
 }
 
+// State events all carry the id of the most recently emitted event, since
+// that's the point in the stream they reflect.
 // Assumes the caller holds the lock.
 func (e *EventEmitter) currentStateAsEvents() []Event {
 	events := make([]Event, 0, len(e.messages)+2)
 	for _, msg := range e.messages {
 		events = append(events, Event{
+			Id:      e.eventIdx,
 			Type:    EventTypeMessageUpdate,
 			Payload: MessageUpdateBody{Id: msg.Id, Role: msg.Role, Message: msg.Message, Time: msg.Time},
 		})
 	}
 	events = append(events, Event{
+		Id:      e.eventIdx,
 		Type:    EventTypeStatusChange,
 		Payload: StatusChangeBody{Status: e.status, AgentType: e.agentType},
 	})
 	events = append(events, Event{
+		Id:      e.eventIdx,
 		Type:    EventTypeScreenUpdate,
 		Payload: ScreenUpdateBody{Screen: strings.TrimRight(e.screen, mf.WhiteSpaceChars)},
 	})
@@ -204,6 +226,14 @@ func (e *EventEmitter) Subscribe() (int, <-chan Event, []Event) {
 	defer e.mu.Unlock()
 	stateEvents := e.currentStateAsEvents()
 
+	if e.closed {
+		ch := make(chan Event, 1)
+		ch <- Event{Id: e.eventIdx, Type: EventTypeShutdown, Payload: shutdownBody}
+		close(ch)
+		e.chanIdx++
+		return e.chanIdx - 1, ch, stateEvents
+	}
+
 	// Once a channel becomes full, it will be closed.
 	ch := make(chan Event, e.subscriptionBufSize)
 	e.chans[e.chanIdx] = ch
@@ -213,7 +243,12 @@ func (e *EventEmitter) Subscribe() (int, <-chan Event, []Event) {
 
 // Assumes the caller holds the lock.
 func (e *EventEmitter) unsubscribeInner(chanId int) {
-	close(e.chans[chanId])
+	ch, ok := e.chans[chanId]
+	if !ok {
+		// Already closed by Shutdown or because the channel was full.
+		return
+	}
+	close(ch)
 	delete(e.chans, chanId)
 }
 
@@ -222,3 +257,21 @@ func (e *EventEmitter) Unsubscribe(chanId int) {
 	defer e.mu.Unlock()
 	e.unsubscribeInner(chanId)
 }
+
+var shutdownBody = ShutdownBody{Message: "server is shutting down"}
+
+// Shutdown sends a shutdown event to every subscriber and then closes their
+// channels so that listeners return. Subscriptions created afterwards receive
+// the shutdown event and are closed right away.
+func (e *EventEmitter) Shutdown() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	e.notifyChannels(EventTypeShutdown, shutdownBody)
+	for chanId := range e.chans {
+		e.unsubscribeInner(chanId)
+	}
+}
@@ -32,6 +32,7 @@ func TestEventEmitter(t *testing.T) {
 		})
 		newEvent := <-ch
 		assert.Equal(t, Event{
+			Id:      1,
 			Type:    EventTypeMessageUpdate,
 			Payload: MessageUpdateBody{Id: 1, Message: "Hello, world!", Role: st.ConversationRoleUser, Time: now},
 		}, newEvent)
@@ -42,12 +43,14 @@ func TestEventEmitter(t *testing.T) {
 		})
 		newEvent = <-ch
 		assert.Equal(t, Event{
+			Id:      2,
 			Type:    EventTypeMessageUpdate,
 			Payload: MessageUpdateBody{Id: 1, Message: "Hello, world! (updated)", Role: st.ConversationRoleUser, Time: now},
 		}, newEvent)
 
 		newEvent = <-ch
 		assert.Equal(t, Event{
+			Id:      3,
 			Type:    EventTypeMessageUpdate,
 			Payload: MessageUpdateBody{Id: 2, Message: "What's up?", Role: st.ConversationRoleAgent, Time: now},
 		}, newEvent)
@@ -55,6 +58,7 @@ func TestEventEmitter(t *testing.T) {
 		emitter.UpdateStatusAndEmitChanges(st.ConversationStatusStable, mf.AgentTypeAider)
 		newEvent = <-ch
 		assert.Equal(t, Event{
+			Id:      4,
 			Type:    EventTypeStatusChange,
 			Payload: StatusChangeBody{Status: AgentStatusStable, AgentType: mf.AgentTypeAider},
 		}, newEvent)
@@ -75,12 +79,25 @@ func TestEventEmitter(t *testing.T) {
 		for _, ch := range channels {
 			newEvent := <-ch
 			assert.Equal(t, Event{
+				Id:      1,
 				Type:    EventTypeMessageUpdate,
 				Payload: MessageUpdateBody{Id: 1, Message: "Hello, world!", Role: st.ConversationRoleUser, Time: now},
 			}, newEvent)
 		}
 	})
 
+	t.Run("state-events-carry-last-id", func(t *testing.T) {
+		emitter := NewEventEmitter(10)
+		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+			{Id: 1, Message: "Hello, world!", Role: st.ConversationRoleUser, Time: time.Now()},
+		})
+		emitter.UpdateStatusAndEmitChanges(st.ConversationStatusStable, mf.AgentTypeAider)
+		_, _, stateEvents := emitter.Subscribe()
+		for _, event := range stateEvents {
+			assert.Equal(t, 2, event.Id)
+		}
+	})
+
 	t.Run("close-channel", func(t *testing.T) {
 		emitter := NewEventEmitter(1)
 		_, ch, _ := emitter.Subscribe()
@@ -99,3 +116,28 @@ func TestEventEmitter(t *testing.T) {
 		}
 	})
 }
+
+func TestEventEmitter_Shutdown(t *testing.T) {
+	emitter := NewEventEmitter(10)
+	id, ch, _ := emitter.Subscribe()
+	emitter.Shutdown()
+	event, ok := <-ch
+	assert.True(t, ok)
+	assert.Equal(t, EventTypeShutdown, event.Type)
+	_, ok = <-ch
+	assert.False(t, ok)
+
+	// Unsubscribing after the channel was closed is a no-op.
+	emitter.Unsubscribe(id)
+
+	t.Run("subscribe after shutdown", func(t *testing.T) {
+		id, ch, stateEvents := emitter.Subscribe()
+		assert.NotEmpty(t, stateEvents)
+		event, ok := <-ch
+		assert.True(t, ok)
+		assert.Equal(t, EventTypeShutdown, event.Type)
+		_, ok = <-ch
+		assert.False(t, ok)
+		emitter.Unsubscribe(id)
+	})
+}
@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+)
+
+// rateLimitSweepInterval is how often buckets that have refilled completely
+// are dropped, so that the limiter's memory doesn't grow with every client
+// that ever connected.
+const rateLimitSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps an in-memory token bucket per client and route. Each
+// bucket holds up to perMinute tokens and refills continuously at perMinute
+// tokens per minute, so a client can burst through a full minute's worth of
+// requests and is then held to the steady rate.
+type rateLimiter struct {
+	clock     quartz.Clock
+	perMinute int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(clock quartz.Clock, perMinute int) *rateLimiter {
+	return &rateLimiter{
+		clock:     clock,
+		perMinute: perMinute,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: clock.Now(),
+	}
+}
+
+// allow takes a token from key's bucket. It returns whether the request may
+// proceed, the tokens left, and how long until the next token if it may not.
+func (l *rateLimiter) allow(key string) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	perSecond := float64(l.perMinute) / 60
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*perSecond >= float64(l.perMinute) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.perMinute), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.perMinute), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// rateLimitMiddleware rejects requests with 429 Too Many Requests once a
+// client has used up its budget for a route. Clients are told apart by their
+// remote IP; X-Forwarded-For is deliberately ignored since any client can set
+// it. Routes are the method and path.
+func rateLimitMiddleware(limiter *rateLimiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := r.RemoteAddr
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				client = host
+			}
+			ok, remaining, retryAfter := limiter.allow(client + " " + r.Method + " " + r.URL.Path)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.perMinute))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, fmt.Sprintf("Rate limit exceeded: at most %d requests per minute", limiter.perMinute), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
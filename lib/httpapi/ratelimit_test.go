@@ -0,0 +1,45 @@
+package httpapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("refills over time", func(t *testing.T) {
+		mClock := quartz.NewMock(t)
+		l := newRateLimiter(mClock, 60)
+
+		for i := range 60 {
+			ok, remaining, _ := l.allow("a")
+			assert.True(t, ok)
+			assert.Equal(t, 59-i, remaining)
+		}
+		ok, _, retryAfter := l.allow("a")
+		assert.False(t, ok)
+		assert.Equal(t, time.Second, retryAfter)
+
+		// Other keys have their own budget.
+		ok, _, _ = l.allow("b")
+		assert.True(t, ok)
+
+		mClock.Advance(time.Second)
+		ok, remaining, _ := l.allow("a")
+		assert.True(t, ok)
+		assert.Equal(t, 0, remaining)
+	})
+
+	t.Run("drops full buckets", func(t *testing.T) {
+		mClock := quartz.NewMock(t)
+		l := newRateLimiter(mClock, 60)
+		l.allow("a")
+		l.allow("b")
+
+		mClock.Advance(rateLimitSweepInterval)
+		l.allow("c")
+		assert.Len(t, l.buckets, 1)
+	})
+}
@@ -3,19 +3,25 @@ package httpapi
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 	"unicode"
 
@@ -29,6 +35,7 @@ import (
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/danielgtaylor/huma/v2/sse"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"golang.org/x/xerrors"
 )
@@ -38,6 +45,8 @@ type Server struct {
 	router       chi.Router
 	api          huma.API
 	port         int
+	unixSocket   string
+	socketMode   fs.FileMode
 	srv          *http.Server
 	mu           sync.RWMutex
 	logger       *slog.Logger
@@ -96,6 +105,25 @@ func (s *Server) GetOpenAPI() string {
 // because the action of taking a snapshot takes time too.
 const snapshotInterval = 25 * time.Millisecond
 
+// Timeouts are applied to the underlying http.Server. A zero value disables
+// the corresponding timeout.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	// Write doesn't cut SSE streams short: huma's sse package pushes the
+	// connection's write deadline forward before sending each event.
+	Write time.Duration
+	Idle  time.Duration
+}
+
+// AccessLogConfig controls the per-request access log.
+type AccessLogConfig struct {
+	Disabled bool
+	// ExcludedPaths are not logged. An entry ending in "*" excludes every
+	// path with that prefix.
+	ExcludedPaths []string
+}
+
 type ServerConfig struct {
 	AgentType      mf.AgentType
 	Process        *termexec.Process
@@ -105,6 +133,33 @@ type ServerConfig struct {
 	AllowedOrigins []string
 	InitialPrompt  string
 	Clock          quartz.Clock
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set. During TLS
+	// handshakes, at most once every 10 seconds, the files are checked for
+	// changes and re-read. TLSMinVersion is one of the tls.VersionTLS*
+	// constants; the zero value means TLS 1.2. TLSClientCAFile, if set,
+	// requires clients to present a certificate signed by one of its CAs.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSMinVersion   uint16
+	TLSClientCAFile string
+	// UnixSocket, if set, is the path of a unix domain socket to listen on
+	// instead of the TCP port. UnixSocketMode sets its file permissions; the
+	// zero value means 0600.
+	UnixSocket     string
+	UnixSocketMode fs.FileMode
+	Timeouts       Timeouts
+	AccessLog      AccessLogConfig
+	// RouteTimeouts are request deadlines by path. The event streams never
+	// get one.
+	RouteTimeouts []RouteTimeout
+	// RateLimit caps the requests per minute each client IP can make to each
+	// route (method and path). Zero disables rate limiting.
+	RateLimit int
+	// Middlewares are installed in order after the built-in ones, so they
+	// only see requests that passed the host, CORS, rate limit and timeout
+	// checks. Routes are registered on the router right after, which is why
+	// middleware can't be added to a Server once it exists.
+	Middlewares []func(http.Handler) http.Handler
 }
 
 // Validate allowed hosts don't contain whitespace, commas, schemes, or ports.
@@ -200,6 +255,20 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 	if config.Clock == nil {
 		config.Clock = quartz.NewReal()
 	}
+	if config.UnixSocketMode&^fs.ModePerm != 0 {
+		return nil, xerrors.Errorf("unix socket mode %#o must only contain permission bits", config.UnixSocketMode)
+	}
+	if config.UnixSocketMode == 0 {
+		config.UnixSocketMode = 0o600
+	}
+	if config.RateLimit < 0 {
+		return nil, xerrors.Errorf("rate limit must not be negative")
+	}
+	for _, rt := range config.RouteTimeouts {
+		if rt.Pattern == "" || rt.Timeout <= 0 {
+			return nil, xerrors.Errorf("route timeout %q=%s needs a pattern and a positive timeout", rt.Pattern, rt.Timeout)
+		}
+	}
 
 	allowedHosts, err := parseAllowedHosts(config.AllowedHosts)
 	if err != nil {
@@ -210,9 +279,46 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 		return nil, xerrors.Errorf("failed to parse allowed origins: %w", err)
 	}
 
+	var tlsConfig *tls.Config
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, xerrors.Errorf("both a TLS certificate and a TLS key must be provided")
+		}
+		if config.TLSMinVersion == 0 {
+			config.TLSMinVersion = tls.VersionTLS12
+		}
+		if config.TLSMinVersion < tls.VersionTLS12 {
+			return nil, xerrors.Errorf("TLS versions older than 1.2 are not supported")
+		}
+		reloader, err := newCertReloader(logger, config.Clock, config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to set up TLS: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:     config.TLSMinVersion,
+			GetCertificate: reloader.GetCertificate,
+		}
+		if config.TLSClientCAFile != "" {
+			clientCAs, err := loadCertPool(config.TLSClientCAFile)
+			if err != nil {
+				return nil, xerrors.Errorf("failed to load TLS client CAs: %w", err)
+			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if config.TLSClientCAFile != "" {
+		return nil, xerrors.Errorf("a TLS client CA requires a TLS certificate and key")
+	}
+
 	logger.Info(fmt.Sprintf("Allowed hosts: %s", strings.Join(allowedHosts, ", ")))
 	logger.Info(fmt.Sprintf("Allowed origins: %s", strings.Join(allowedOrigins, ", ")))
 
+	// Log every request, including the ones rejected below.
+	router.Use(requestIDMiddleware)
+	if !config.AccessLog.Disabled {
+		router.Use(accessLogMiddleware(logger, config.AccessLog.ExcludedPaths))
+	}
+
 	// Enforce allowed hosts in a custom middleware that ignores the port during matching.
 	badHostHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid host header. Allowed hosts: "+strings.Join(allowedHosts, ", "), http.StatusBadRequest)
@@ -222,13 +328,22 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", RequestIDHeader},
+		ExposedHeaders:   []string{"Link", RequestIDHeader, "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	})
 	router.Use(corsMiddleware.Handler)
 
+	// Rate limit after CORS, so that browsers can read the 429 responses.
+	if config.RateLimit > 0 {
+		router.Use(rateLimitMiddleware(newRateLimiter(config.Clock, config.RateLimit)))
+	}
+	if len(config.RouteTimeouts) > 0 {
+		router.Use(routeTimeoutMiddleware(config.RouteTimeouts))
+	}
+	router.Use(config.Middlewares...)
+
 	humaConfig := huma.DefaultConfig("AgentAPI", version.Version)
 	humaConfig.Info.Description = "HTTP API for Claude Code, Goose, and Aider.\n\nhttps://github.com/coder/agentapi"
 	api := humachi.New(router, humaConfig)
@@ -284,6 +399,8 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 		router:       router,
 		api:          api,
 		port:         config.Port,
+		unixSocket:   config.UnixSocket,
+		socketMode:   config.UnixSocketMode,
 		conversation: conversation,
 		logger:       logger,
 		agentio:      config.Process,
@@ -293,6 +410,22 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 		tempDir:      tempDir,
 		clock:        config.Clock,
 	}
+	// The http.Server is built up front so that Stop works even if it runs
+	// before, or concurrently with, Start.
+	s.srv = &http.Server{
+		Handler:           router,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: config.Timeouts.ReadHeader,
+		ReadTimeout:       config.Timeouts.Read,
+		WriteTimeout:      config.Timeouts.Write,
+		IdleTimeout:       config.Timeouts.Idle,
+	}
+	if config.UnixSocket == "" {
+		s.srv.Addr = fmt.Sprintf(":%d", config.Port)
+	}
+	// Shutdown doesn't cancel in-flight requests, so end the SSE streams
+	// explicitly or they would keep it waiting until its context expires.
+	s.srv.RegisterOnShutdown(emitter.Shutdown)
 
 	// Register API routes
 	s.registerRoutes()
@@ -349,6 +482,47 @@ func hostAuthorizationMiddleware(allowedHosts []string, badHostHandler http.Hand
 	}
 }
 
+// pathMatches reports whether path is pattern, or starts with pattern's
+// prefix if pattern ends in "*".
+func pathMatches(pattern, path string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return pattern == path
+}
+
+// accessLogMiddleware logs the method, path, status, response size, duration
+// and request ID of every request whose path isn't excluded. See
+// AccessLogConfig.ExcludedPaths for the matching rules.
+func accessLogMiddleware(logger *slog.Logger, excludedPaths []string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.ContainsFunc(excludedPaths, func(pattern string) bool { return pathMatches(pattern, r.URL.Path) }) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					// Nothing was written, so net/http responds with 200.
+					status = http.StatusOK
+				}
+				logger.Info("HTTP request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", status,
+					"bytes", ww.BytesWritten(),
+					"duration", time.Since(start),
+					"remoteAddr", r.RemoteAddr,
+					"requestId", RequestID(r.Context()))
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
 // sseMiddleware creates middleware that prevents proxy buffering for SSE endpoints
 func sseMiddleware(ctx huma.Context, next func(huma.Context)) {
 	// Disable proxy buffering for SSE endpoints
@@ -389,12 +563,13 @@ func (s *Server) registerRoutes() {
 		Method:      http.MethodGet,
 		Path:        "/events",
 		Summary:     "Subscribe to events",
-		Description: "The events are sent as Server-Sent Events (SSE). Initially, the endpoint returns a list of events needed to reconstruct the current state of the conversation and the agent's status. After that, it only returns events that have occurred since the last event was sent.\n\nNote: When an agent is running, the last message in the conversation history is updated frequently, and the endpoint sends a new message update event each time.",
+		Description: "The events are sent as Server-Sent Events (SSE). Initially, the endpoint returns a list of events needed to reconstruct the current state of the conversation and the agent's status. After that, it only returns events that have occurred since the last event was sent.\n\nEvent ids are opaque and only increase. The Last-Event-ID header is ignored: a client that reconnects receives the full current state again, as on its first connection.\n\nNote: When an agent is running, the last message in the conversation history is updated frequently, and the endpoint sends a new message update event each time.",
 		Middlewares: []func(huma.Context, func(huma.Context)){sseMiddleware},
 	}, map[string]any{
 		// Mapping of event type name to Go struct for that event.
-		"message_update": MessageUpdateBody{},
-		"status_change":  StatusChangeBody{},
+		"message_update":  MessageUpdateBody{},
+		"status_change":   StatusChangeBody{},
+		"server_shutdown": ShutdownBody{},
 	}, s.subscribeEvents)
 
 	sse.Register(s.api, huma.Operation{
@@ -405,7 +580,8 @@ func (s *Server) registerRoutes() {
 		Hidden:      true,
 		Middlewares: []func(huma.Context, func(huma.Context)){sseMiddleware},
 	}, map[string]any{
-		"screen": ScreenUpdateBody{},
+		"screen":          ScreenUpdateBody{},
+		"server_shutdown": ShutdownBody{},
 	}, s.subscribeScreen)
 
 	s.router.Handle("/", http.HandlerFunc(s.redirectToChat))
@@ -515,17 +691,21 @@ func (s *Server) uploadFiles(ctx context.Context, input *struct {
 	return resp, nil
 }
 
-// subscribeEvents is an SSE endpoint that sends events to the client
-func (s *Server) subscribeEvents(ctx context.Context, input *struct{}, send sse.Sender) {
+// streamEvents subscribes to the emitter and forwards every event accepted
+// by filter to the client, starting with the events that reconstruct the
+// current state. It returns when the client disconnects, a send fails, or
+// the subscription is dropped by the emitter.
+func (s *Server) streamEvents(ctx context.Context, send sse.Sender, logger *slog.Logger, filter func(Event) bool) {
 	subscriberId, ch, stateEvents := s.emitter.Subscribe()
 	defer s.emitter.Unsubscribe(subscriberId)
-	s.logger.Info("New subscriber", "subscriberId", subscriberId)
+	logger = logger.With("subscriberId", subscriberId)
+	logger.Info("New subscriber")
 	for _, event := range stateEvents {
-		if event.Type == EventTypeScreenUpdate {
+		if !filter(event) {
 			continue
 		}
-		if err := send.Data(event.Payload); err != nil {
-			s.logger.Error("Failed to send event", "subscriberId", subscriberId, "error", err)
+		if err := send(sse.Message{ID: event.Id, Data: event.Payload}); err != nil {
+			logger.Error("Failed to send event", "error", err)
 			return
 		}
 	}
@@ -534,77 +714,138 @@ func (s *Server) subscribeEvents(ctx context.Context, input *struct{}, send sse.
 		select {
 		case event, ok := <-ch:
 			if !ok {
-				s.logger.Info("Channel closed", "subscriberId", subscriberId)
+				logger.Info("Channel closed")
 				return
 			}
-			if event.Type == EventTypeScreenUpdate {
+			if !filter(event) {
 				continue
 			}
-			if err := send.Data(event.Payload); err != nil {
-				s.logger.Error("Failed to send event", "subscriberId", subscriberId, "error", err)
+			if err := send(sse.Message{ID: event.Id, Data: event.Payload}); err != nil {
+				logger.Error("Failed to send event", "error", err)
 				return
 			}
 		case <-ctx.Done():
-			s.logger.Info("Context done", "subscriberId", subscriberId)
+			logger.Info("Context done")
 			return
 		}
 	}
 }
 
+// subscribeEvents is an SSE endpoint that sends events to the client
+func (s *Server) subscribeEvents(ctx context.Context, input *struct{}, send sse.Sender) {
+	s.streamEvents(ctx, send, s.logger.With("stream", "events"), func(event Event) bool {
+		return event.Type != EventTypeScreenUpdate
+	})
+}
+
 func (s *Server) subscribeScreen(ctx context.Context, input *struct{}, send sse.Sender) {
-	subscriberId, ch, stateEvents := s.emitter.Subscribe()
-	defer s.emitter.Unsubscribe(subscriberId)
-	s.logger.Info("New screen subscriber", "subscriberId", subscriberId)
-	for _, event := range stateEvents {
-		if event.Type != EventTypeScreenUpdate {
-			continue
-		}
-		if err := send.Data(event.Payload); err != nil {
-			s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
-			return
-		}
+	s.streamEvents(ctx, send, s.logger.With("stream", "screen"), func(event Event) bool {
+		return event.Type == EventTypeScreenUpdate || event.Type == EventTypeShutdown
+	})
+}
+
+// Start starts the HTTP server. It returns http.ErrServerClosed once Stop
+// has been called, including when Stop ran first.
+func (s *Server) Start() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
 	}
-	for {
-		select {
-		case event, ok := <-ch:
-			if !ok {
-				s.logger.Info("Screen channel closed", "subscriberId", subscriberId)
-				return
-			}
-			if event.Type != EventTypeScreenUpdate {
-				continue
-			}
-			if err := send.Data(event.Payload); err != nil {
-				s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
-				return
-			}
-		case <-ctx.Done():
-			s.logger.Info("Screen context done", "subscriberId", subscriberId)
-			return
-		}
+	if s.srv.TLSConfig != nil {
+		// The certificate is provided by TLSConfig.GetCertificate.
+		return s.srv.ServeTLS(ln, "", "")
 	}
+	return s.srv.Serve(ln)
 }
 
-// Start starts the HTTP server
-func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%d", s.port)
-	s.srv = &http.Server{
-		Addr:    addr,
-		Handler: s.router,
+// systemdListenFdsStart is the first file descriptor passed by systemd socket
+// activation. See sd_listen_fds(3).
+const systemdListenFdsStart = 3
+
+// systemdListener returns the listener passed by systemd socket activation,
+// or nil if the process wasn't socket activated.
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, xerrors.Errorf("expected a single socket from systemd, got %d", fds)
+	}
+	// Like sd_listen_fds(3), consume the variables once they've been read.
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFdsStart, "systemd-socket")
+	defer func() {
+		_ = f.Close()
+	}()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to use socket passed by systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// listen uses the socket passed by systemd if the process was socket
+// activated. Otherwise it opens the unix socket if one is configured, and the
+// TCP port if not.
+func (s *Server) listen() (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		if ln != nil {
+			s.logger.Info("Starting server on socket passed by systemd", "addr", ln.Addr().String())
+		}
+		return ln, err
+	}
+	if s.unixSocket == "" {
+		s.logger.Info("Starting server on port", "port", s.port)
+		return net.Listen("tcp", s.srv.Addr)
 	}
 
-	return s.srv.ListenAndServe()
+	// Remove a socket left behind by a previous run that didn't shut down
+	// cleanly, but never clobber anything that isn't a socket or a socket that
+	// another process is still serving.
+	if info, err := os.Lstat(s.unixSocket); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, xerrors.Errorf("%s already exists and is not a socket", s.unixSocket)
+		}
+		conn, err := net.DialTimeout("unix", s.unixSocket, time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil, xerrors.Errorf("another process is already listening on %s", s.unixSocket)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, xerrors.Errorf("failed to check whether %s is in use: %w", s.unixSocket, err)
+		}
+		if err := os.Remove(s.unixSocket); err != nil {
+			return nil, xerrors.Errorf("failed to remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, xerrors.Errorf("failed to stat socket path: %w", err)
+	}
+
+	s.logger.Info("Starting server on unix socket", "path", s.unixSocket)
+	ln, err := net.Listen("unix", s.unixSocket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(s.unixSocket, s.socketMode); err != nil {
+		_ = ln.Close()
+		return nil, xerrors.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
 }
 
 // Stop gracefully stops the HTTP server
 func (s *Server) Stop(ctx context.Context) error {
-	// Clean up temporary directory
+	err := s.srv.Shutdown(ctx)
+	// Clean up temporary directory only once in-flight uploads are done
 	s.cleanupTempDir()
-
-	if s.srv != nil {
-		return s.srv.Shutdown(ctx)
-	}
-	return nil
+	return err
 }
 
 // cleanupTempDir removes the temporary directory and all its contents
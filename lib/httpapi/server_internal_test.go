@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SSEOutlivesWriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	const writeTimeout = 50 * time.Millisecond
+	socketPath := filepath.Join(t.TempDir(), "agentapi.sock")
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	srv, err := NewServer(ctx, ServerConfig{
+		AgentType:      mf.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     socketPath,
+		Timeouts: Timeouts{
+			Write: writeTimeout,
+		},
+	})
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Start()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+	WaitForSocket(t, socketPath)
+
+	client := UnixSocketClient(socketPath, nil)
+	resp, err := client.Get("http://localhost/events")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Emit an event only after the write deadline set by the server has passed.
+	time.Sleep(4 * writeTimeout)
+	srv.emitter.UpdateStatusAndEmitChanges(st.ConversationStatusStable, mf.AgentTypeClaude)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), `"status":"stable"`) {
+			return
+		}
+	}
+	t.Fatalf("stream ended before the status change was received: %v", scanner.Err())
+}
+
+func TestSystemdListener(t *testing.T) {
+	t.Run("not activated", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", "")
+		t.Setenv("LISTEN_FDS", "")
+		ln, err := systemdListener()
+		require.NoError(t, err)
+		require.Nil(t, ln)
+	})
+
+	t.Run("activated for another process", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+		t.Setenv("LISTEN_FDS", "1")
+		ln, err := systemdListener()
+		require.NoError(t, err)
+		require.Nil(t, ln)
+	})
+
+	t.Run("more than one socket", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "2")
+		_, err := systemdListener()
+		require.ErrorContains(t, err, "expected a single socket")
+	})
+}
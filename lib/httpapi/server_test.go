@@ -7,12 +7,17 @@ import (
 	"io"
 	"log/slog"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coder/agentapi/lib/httpapi"
 	"github.com/coder/agentapi/lib/logctx"
@@ -657,6 +662,254 @@ func assertSSEHeaders(t testing.TB, resp *http.Response) {
 	assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
 }
 
+type accessLogEntry struct {
+	Msg       string `json:"msg"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Bytes     int    `json:"bytes"`
+	RequestID string `json:"requestId"`
+}
+
+// serveAndCollectAccessLogs serves each URL with a GET request and returns the
+// access log entries written while doing so.
+func serveAndCollectAccessLogs(t *testing.T, config httpapi.AccessLogConfig, urls ...string) []accessLogEntry {
+	t.Helper()
+
+	var logs bytes.Buffer
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewJSONHandler(&logs, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"localhost"},
+		AllowedOrigins: []string{"*"},
+		AccessLog:      config,
+	})
+	require.NoError(t, err)
+
+	for _, url := range urls {
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	var entries []accessLogEntry
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry accessLogEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry.Msg == "HTTP request" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	t.Parallel()
+
+	t.Run("logs requests", func(t *testing.T) {
+		t.Parallel()
+		entries := serveAndCollectAccessLogs(t, httpapi.AccessLogConfig{},
+			"http://localhost/messages",
+			"http://localhost/status",
+			"http://example.com/messages",
+		)
+
+		// Requests rejected by the host check are logged too.
+		require.Len(t, entries, 3)
+		assert.Equal(t, http.MethodGet, entries[0].Method)
+		assert.Equal(t, "/messages", entries[0].Path)
+		assert.Equal(t, http.StatusOK, entries[0].Status)
+		assert.Positive(t, entries[0].Bytes)
+		assert.NotEmpty(t, entries[0].RequestID)
+		assert.Equal(t, "/status", entries[1].Path)
+		assert.Equal(t, "/messages", entries[2].Path)
+		assert.Equal(t, http.StatusBadRequest, entries[2].Status)
+		assert.NotEqual(t, entries[0].RequestID, entries[2].RequestID)
+	})
+
+	t.Run("excluded paths", func(t *testing.T) {
+		t.Parallel()
+		entries := serveAndCollectAccessLogs(t, httpapi.AccessLogConfig{ExcludedPaths: []string{"/status", "/chat/*"}},
+			"http://localhost/messages",
+			"http://localhost/status",
+			"http://localhost/status/",
+			"http://localhost/chat/index.html",
+			"http://localhost/chat",
+		)
+
+		paths := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			paths = append(paths, entry.Path)
+		}
+		assert.Equal(t, []string{"/messages", "/status/", "/chat"}, paths)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		entries := serveAndCollectAccessLogs(t, httpapi.AccessLogConfig{Disabled: true}, "http://localhost/messages")
+		assert.Empty(t, entries)
+	})
+}
+
+func TestServer_RequestID(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+	})
+	require.NoError(t, err)
+
+	uuidV7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name       string
+		incoming   string
+		expectedID string
+	}{
+		{name: "generated", incoming: ""},
+		{name: "incoming", incoming: "proxy-1234", expectedID: "proxy-1234"},
+		{name: "incoming with spaces", incoming: "not an id"},
+		{name: "incoming too long", incoming: strings.Repeat("a", 129)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, "/status", nil)
+			if tt.incoming != "" {
+				req.Header.Set(httpapi.RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			id := rec.Header().Get(httpapi.RequestIDHeader)
+			if tt.expectedID != "" {
+				assert.Equal(t, tt.expectedID, id)
+			} else {
+				assert.Regexp(t, uuidV7, id)
+			}
+		})
+	}
+
+	t.Run("unique", func(t *testing.T) {
+		t.Parallel()
+		ids := make(map[string]struct{})
+		for range 100 {
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+			ids[rec.Header().Get(httpapi.RequestIDHeader)] = struct{}{}
+		}
+		assert.Len(t, ids, 100)
+	})
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		RateLimit:      2,
+	})
+	require.NoError(t, err)
+
+	get := func(remoteAddr, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, remaining := range []string{"1", "0"} {
+		rec := get("192.0.2.1:1234", "/status")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, remaining, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	// The port doesn't matter, only the client IP does.
+	rec := get("192.0.2.1:5678", "/status")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+
+	// Other routes and other clients have their own budget.
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:1234", "/messages").Code)
+	assert.Equal(t, http.StatusOK, get("192.0.2.2:1234", "/status").Code)
+}
+
+func TestNewServer_InvalidRequestLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		rateLimit     int
+		routeTimeouts []httpapi.RouteTimeout
+		expectedError string
+	}{
+		{name: "negative rate limit", rateLimit: -1, expectedError: "rate limit must not be negative"},
+		{name: "route timeout without pattern", routeTimeouts: []httpapi.RouteTimeout{{Timeout: time.Second}}, expectedError: "needs a pattern and a positive timeout"},
+		{name: "zero route timeout", routeTimeouts: []httpapi.RouteTimeout{{Pattern: "/status"}}, expectedError: "needs a pattern and a positive timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+			_, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+				AgentType:      msgfmt.AgentTypeClaude,
+				Process:        nil,
+				ChatBasePath:   "/chat",
+				AllowedHosts:   []string{"*"},
+				AllowedOrigins: []string{"*"},
+				RateLimit:      tt.rateLimit,
+				RouteTimeouts:  tt.routeTimeouts,
+			})
+			require.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestServer_Middlewares(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	tagging := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"localhost"},
+		AllowedOrigins: []string{"*"},
+		Middlewares:    []func(http.Handler) http.Handler{tagging("first"), tagging("second")},
+	})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/status", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"first", "second"}, rec.Header().Values("X-Middleware"))
+
+	// Requests rejected by the built-in host check never reach them.
+	calls = nil
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/status", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, calls)
+}
+
 func TestServer_UploadFiles(t *testing.T) {
 	t.Parallel()
 	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
@@ -956,3 +1209,293 @@ func TestServer_UploadFiles_Errors(t *testing.T) {
 		require.Contains(t, string(body), "file size exceeds 10MB limit")
 	})
 }
+
+func TestServer_UnixSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "agentapi.sock")
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"localhost"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     socketPath,
+		UnixSocketMode: 0o660,
+	})
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Start()
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Stop(context.Background()))
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+		_, err := os.Stat(socketPath)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	httpapi.WaitForSocket(t, socketPath)
+
+	client := httpapi.UnixSocketClient(socketPath, nil)
+	resp, err := client.Get("http://localhost/status")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// The mode is set before the server starts serving.
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o660), info.Mode().Perm())
+}
+
+func TestServer_UnixSocket_RefusesToClobberFiles(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "not-a-socket")
+	require.NoError(t, os.WriteFile(socketPath, []byte("keep me"), 0o644))
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     socketPath,
+	})
+	require.NoError(t, err)
+
+	require.ErrorContains(t, srv.Start(), "is not a socket")
+	content, err := os.ReadFile(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, "keep me", string(content))
+}
+
+func TestServer_ReadHeaderTimeout(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "agentapi.sock")
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     socketPath,
+		Timeouts: httpapi.Timeouts{
+			ReadHeader: 50 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Start()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+	httpapi.WaitForSocket(t, socketPath)
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	_, err = conn.Write([]byte("GET /status HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+
+	// The server gives up on the request and closes the connection
+	// without ever writing a response.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+}
+
+func TestServer_StopClosesEventStreams(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(t.TempDir(), "agentapi.sock")
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     socketPath,
+	})
+	require.NoError(t, err)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Start()
+	}()
+	httpapi.WaitForSocket(t, socketPath)
+
+	client := httpapi.UnixSocketClient(socketPath, nil)
+	resp, err := client.Get("http://localhost/events")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Stop(stopCtx))
+	require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+
+	// The stream ends with a notice instead of hanging on to the connection.
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "event: server_shutdown\n")
+}
+
+func TestServer_StopBeforeStart(t *testing.T) {
+	t.Parallel()
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     filepath.Join(t.TempDir(), "agentapi.sock"),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, srv.Stop(context.Background()))
+	require.ErrorIs(t, srv.Start(), http.ErrServerClosed)
+}
+
+func TestServer_SystemdSocketActivation(t *testing.T) {
+	if os.Getenv("AGENTAPI_TEST_SYSTEMD_CHILD") == "1" {
+		// systemd sets LISTEN_PID after forking, so the child has to do it.
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+			AgentType:      msgfmt.AgentTypeClaude,
+			Process:        nil,
+			Port:           0,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"*"},
+		})
+		require.NoError(t, err)
+		// Serves until the parent kills the process.
+		require.NoError(t, srv.Start())
+		return
+	}
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f, err := ln.(*net.TCPListener).File()
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	// The child owns the socket through the duplicated file descriptor.
+	require.NoError(t, ln.Close())
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServer_SystemdSocketActivation$")
+	cmd.Env = append(os.Environ(), "AGENTAPI_TEST_SYSTEMD_CHILD=1", "LISTEN_FDS=1")
+	// ExtraFiles[0] becomes fd 3 in the child.
+	cmd.ExtraFiles = []*os.File{f}
+	require.NoError(t, cmd.Start())
+	require.NoError(t, f.Close())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + addr + "/status")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServer_UnixSocket_ExistingSocket(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T, socketPath string) *httpapi.Server {
+		t.Helper()
+		ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+			AgentType:      msgfmt.AgentTypeClaude,
+			Process:        nil,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"*"},
+			UnixSocket:     socketPath,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = srv.Stop(context.Background())
+		})
+		return srv
+	}
+
+	t.Run("in use", func(t *testing.T) {
+		t.Parallel()
+
+		socketPath := filepath.Join(t.TempDir(), "agentapi.sock")
+		ln, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = ln.Close()
+		})
+
+		srv := newServer(t, socketPath)
+		require.ErrorContains(t, srv.Start(), "already listening")
+
+		// The other listener keeps its socket.
+		conn, err := net.Dial("unix", socketPath)
+		require.NoError(t, err)
+		_ = conn.Close()
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		t.Parallel()
+
+		socketPath := filepath.Join(t.TempDir(), "agentapi.sock")
+		ln, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+		// Simulate a crashed process that left its socket file behind.
+		ln.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, ln.Close())
+
+		srv := newServer(t, socketPath)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- srv.Start()
+		}()
+		httpapi.WaitForSocket(t, socketPath)
+		require.NoError(t, srv.Stop(context.Background()))
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+	})
+}
+
+func TestServer_UnixSocket_InvalidMode(t *testing.T) {
+	t.Parallel()
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	_, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     filepath.Join(t.TempDir(), "agentapi.sock"),
+		UnixSocketMode: os.ModeSetuid | 0o600,
+	})
+	require.ErrorContains(t, err, "must only contain permission bits")
+}
@@ -4,10 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
-	"time"
 
 	"github.com/coder/agentapi/lib/logctx"
 	mf "github.com/coder/agentapi/lib/msgfmt"
@@ -22,6 +19,7 @@ type SetupProcessConfig struct {
 	AgentType      mf.AgentType
 }
 
+// SetupProcess starts the agent. Closing it on shutdown is up to the caller.
 func SetupProcess(ctx context.Context, config SetupProcessConfig) (*termexec.Process, error) {
 	logger := logctx.From(ctx)
 
@@ -46,15 +44,5 @@ func SetupProcess(ctx context.Context, config SetupProcessConfig) (*termexec.Pro
 		}
 	}
 
-	// Handle SIGINT (Ctrl+C) and send it to the process
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-signalCh
-		if err := process.Close(logger, 5*time.Second); err != nil {
-			logger.Error("Error closing process", "error", err)
-		}
-	}()
-
 	return process, nil
 }
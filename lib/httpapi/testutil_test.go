@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The helpers in this file are exported so that the httpapi_test package can
+// use them too; being in a _test.go file keeps them out of the package API.
+
+// WaitForSocket waits until the server started in the background accepts
+// connections on the unix socket at path. Checking for the file isn't enough:
+// it exists as soon as the socket is bound, before it listens.
+func WaitForSocket(t testing.TB, path string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// UnixSocketClient returns an HTTP client that sends every request to the unix
+// socket at path, whatever the URL's host. tlsConfig may be nil.
+func UnixSocketClient(path string, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// RouteTimeout is a request deadline for the paths matching Pattern. Patterns
+// follow the same rules as AccessLogConfig.ExcludedPaths.
+type RouteTimeout struct {
+	Pattern string
+	Timeout time.Duration
+}
+
+// streamingPaths never get a deadline: the streams are meant to stay open.
+var streamingPaths = []string{"/events", "/internal/screen"}
+
+// timeoutWriter buffers a handler's response so that it can be discarded in
+// favor of a 504 if the deadline passes first.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// routeTimeoutMiddleware gives requests to the routes in timeouts a deadline.
+// The first matching pattern wins. The request context is canceled at the
+// deadline, and if the handler hasn't finished by then the client gets a 504
+// with the same problem+json body as other API errors. Handlers that ignore
+// the context keep running in the background, but their output is dropped.
+func routeTimeoutMiddleware(timeouts []RouteTimeout) func(next http.Handler) http.Handler {
+	timeoutFor := func(path string) time.Duration {
+		if slices.Contains(streamingPaths, path) {
+			return 0
+		}
+		for _, rt := range timeouts {
+			if pathMatches(rt.Pattern, path) {
+				return rt.Timeout
+			}
+		}
+		return 0
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := timeoutFor(r.URL.Path)
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicCh := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicCh <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicCh:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				maps.Copy(w.Header(), tw.header)
+				if tw.code == 0 {
+					tw.code = http.StatusOK
+				}
+				w.WriteHeader(tw.code)
+				_, _ = w.Write(tw.buf.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				tw.timedOut = true
+				tw.mu.Unlock()
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusGatewayTimeout)
+				_ = json.NewEncoder(w).Encode(huma.ErrorModel{
+					Title:  http.StatusText(http.StatusGatewayTimeout),
+					Status: http.StatusGatewayTimeout,
+					Detail: fmt.Sprintf("request did not complete within %s", timeout),
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteTimeoutMiddleware(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	lateWriteErr := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "fast")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// Only write once the client has its 504.
+		<-release
+		_, err := w.Write([]byte("too late"))
+		lateWriteErr <- err
+	})
+	mux.HandleFunc("/slow/nested", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("nested"))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("streamed"))
+	})
+	handler := routeTimeoutMiddleware([]RouteTimeout{
+		{Pattern: "/slow/nested", Timeout: time.Hour},
+		{Pattern: "/*", Timeout: 20 * time.Millisecond},
+	})(mux)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run("finishes in time", func(t *testing.T) {
+		rec := serve("/fast")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "fast", rec.Header().Get("X-Test"))
+		assert.Equal(t, "done", rec.Body.String())
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		rec := serve("/slow")
+		require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		var body huma.ErrorModel
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, http.StatusGatewayTimeout, body.Status)
+		assert.Contains(t, body.Detail, "20ms")
+		// The handler's context was canceled and its late write is dropped.
+		close(release)
+		assert.ErrorIs(t, <-lateWriteErr, http.ErrHandlerTimeout)
+	})
+
+	t.Run("first match wins", func(t *testing.T) {
+		rec := serve("/slow/nested")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "nested", rec.Body.String())
+	})
+
+	t.Run("streams never time out", func(t *testing.T) {
+		rec := serve("/events")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "streamed", rec.Body.String())
+	})
+}
@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+	"golang.org/x/xerrors"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes. Handshakes in between reuse the loaded certificate without
+// touching the filesystem.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the key pair at certFile and keyFile and reloads it
+// when either file's modification time changes, so that renewed certificates
+// are picked up without restarting the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+	clock    quartz.Clock
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(logger *slog.Logger, clock quartz.Clock, certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger, clock: clock, lastCheck: clock.Now()}
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(certMod, keyMod); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, xerrors.Errorf("failed to stat TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, xerrors.Errorf("failed to stat TLS key: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// Assumes the caller holds the lock or that the reloader is not yet shared.
+func (r *certReloader) load(certMod, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return xerrors.Errorf("failed to load TLS key pair: %w", err)
+	}
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. If the files changed
+// but can't be loaded (e.g. the certificate was written before the key), the
+// previous certificate keeps being served and loading is retried at the next
+// check.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	if now.Sub(r.lastCheck) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = now
+
+	certMod, keyMod, err := r.modTimes()
+	if err == nil && (!certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)) {
+		if err := r.load(certMod, keyMod); err != nil {
+			r.logger.Error("Failed to reload TLS certificate, serving the previous one", "error", err)
+		} else {
+			r.logger.Info("Reloaded TLS certificate", "certFile", r.certFile)
+		}
+	}
+	return r.cert, nil
+}
+
+// loadCertPool reads the PEM-encoded certificates in file into a pool.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pemCerts, err := os.ReadFile(file)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, xerrors.Errorf("no PEM-encoded certificates found in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,265 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair writes a fresh self-signed certificate for commonName and its
+// key to certFile and keyFile.
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+}
+
+func servedCommonName(t *testing.T, r *certReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	logger := slog.New(logctx.DiscardHandler)
+	mClock := quartz.NewMock(t)
+	ctx := context.Background()
+
+	t.Run("reloads-changed-files", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		writeKeyPair(t, certFile, keyFile, "first")
+
+		r, err := newCertReloader(logger, mClock, certFile, keyFile)
+		require.NoError(t, err)
+		assert.Equal(t, "first", servedCommonName(t, r))
+
+		writeKeyPair(t, certFile, keyFile, "second")
+		// Make sure the change is visible even on filesystems with coarse mtimes.
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(certFile, later, later))
+		require.NoError(t, os.Chtimes(keyFile, later, later))
+		// The files aren't checked again until the interval has passed.
+		assert.Equal(t, "first", servedCommonName(t, r))
+		mClock.Advance(certCheckInterval).MustWait(ctx)
+		assert.Equal(t, "second", servedCommonName(t, r))
+	})
+
+	t.Run("keeps-previous-certificate-on-invalid-files", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		writeKeyPair(t, certFile, keyFile, "first")
+
+		r, err := newCertReloader(logger, mClock, certFile, keyFile)
+		require.NoError(t, err)
+
+		require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(keyFile, later, later))
+		mClock.Advance(certCheckInterval).MustWait(ctx)
+		assert.Equal(t, "first", servedCommonName(t, r))
+	})
+
+	t.Run("missing-files", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := newCertReloader(logger, mClock, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+		require.Error(t, err)
+	})
+}
+
+func TestServer_TLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "agentapi")
+	socketPath := filepath.Join(dir, "agentapi.sock")
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	srv, err := NewServer(ctx, ServerConfig{
+		AgentType:      mf.AgentTypeClaude,
+		Process:        nil,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		UnixSocket:     socketPath,
+		TLSCertFile:    certFile,
+		TLSKeyFile:     keyFile,
+		TLSMinVersion:  tls.VersionTLS13,
+	})
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Start()
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Stop(context.Background()))
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+	})
+	WaitForSocket(t, socketPath)
+
+	clientWithMaxVersion := func(maxVersion uint16) *http.Client {
+		return UnixSocketClient(socketPath, &tls.Config{
+			// The test certificate is self-signed.
+			InsecureSkipVerify: true, //nolint:gosec
+			MaxVersion:         maxVersion,
+		})
+	}
+
+	t.Run("https", func(t *testing.T) {
+		resp, err := clientWithMaxVersion(tls.VersionTLS13).Get("https://localhost/status")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NotNil(t, resp.TLS)
+		assert.Equal(t, "agentapi", resp.TLS.PeerCertificates[0].Subject.CommonName)
+	})
+
+	t.Run("below min version", func(t *testing.T) {
+		_, err := clientWithMaxVersion(tls.VersionTLS12).Get("https://localhost/status")
+		require.Error(t, err)
+	})
+}
+
+func TestServer_TLSClientCA(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "agentapi")
+	// The self-signed client certificate doubles as its own CA.
+	clientCertFile := filepath.Join(dir, "client-cert.pem")
+	clientKeyFile := filepath.Join(dir, "client-key.pem")
+	writeKeyPair(t, clientCertFile, clientKeyFile, "client")
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	require.NoError(t, err)
+	socketPath := filepath.Join(dir, "agentapi.sock")
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	srv, err := NewServer(ctx, ServerConfig{
+		AgentType:       mf.AgentTypeClaude,
+		Process:         nil,
+		ChatBasePath:    "/chat",
+		AllowedHosts:    []string{"*"},
+		AllowedOrigins:  []string{"*"},
+		UnixSocket:      socketPath,
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+		TLSClientCAFile: clientCertFile,
+	})
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Start()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+	WaitForSocket(t, socketPath)
+
+	clientWithCertificates := func(certs ...tls.Certificate) *http.Client {
+		return UnixSocketClient(socketPath, &tls.Config{
+			// The test certificate is self-signed.
+			InsecureSkipVerify: true, //nolint:gosec
+			Certificates:       certs,
+		})
+	}
+
+	t.Run("with client certificate", func(t *testing.T) {
+		resp, err := clientWithCertificates(clientCert).Get("https://localhost/status")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("without client certificate", func(t *testing.T) {
+		_, err := clientWithCertificates().Get("https://localhost/status")
+		require.Error(t, err)
+	})
+}
+
+func TestNewServer_TLSConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "agentapi")
+
+	tests := []struct {
+		name          string
+		certFile      string
+		keyFile       string
+		minVersion    uint16
+		clientCAFile  string
+		expectedError string
+	}{
+		{name: "cert without key", certFile: certFile, expectedError: "both a TLS certificate and a TLS key must be provided"},
+		{name: "key without cert", keyFile: keyFile, expectedError: "both a TLS certificate and a TLS key must be provided"},
+		{name: "missing files", certFile: filepath.Join(dir, "missing.pem"), keyFile: keyFile, expectedError: "failed to set up TLS"},
+		{name: "old min version", certFile: certFile, keyFile: keyFile, minVersion: tls.VersionTLS11, expectedError: "older than 1.2"},
+		{name: "client CA without cert", clientCAFile: certFile, expectedError: "a TLS client CA requires a TLS certificate and key"},
+		{name: "missing client CA", certFile: certFile, keyFile: keyFile, clientCAFile: filepath.Join(dir, "missing.pem"), expectedError: "failed to load TLS client CAs"},
+		{name: "client CA without certificates", certFile: certFile, keyFile: keyFile, clientCAFile: keyFile, expectedError: "no PEM-encoded certificates found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+			_, err := NewServer(ctx, ServerConfig{
+				AgentType:       mf.AgentTypeClaude,
+				Process:         nil,
+				ChatBasePath:    "/chat",
+				AllowedHosts:    []string{"*"},
+				AllowedOrigins:  []string{"*"},
+				TLSCertFile:     tt.certFile,
+				TLSKeyFile:      tt.keyFile,
+				TLSMinVersion:   tt.minVersion,
+				TLSClientCAFile: tt.clientCAFile,
+			})
+			require.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}